@@ -0,0 +1,187 @@
+// Package abr lazily transcodes a live publish into additional renditions
+// (resolution/bitrate ladder steps) on first viewer request, each as its
+// own short-lived ffmpeg process fed from the stream's FLV broadcaster, and
+// garbage-collects renditions nobody has requested in a while.
+package abr
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Rendition is one ladder step to transcode on demand.
+type Rendition struct {
+	Name           string
+	Height         int
+	VideoBitrateKb int
+	AudioBitrateKb int
+	Encoder        string // ffmpeg video encoder; defaults to libx264
+}
+
+// FLVSource is subscribed to get the same FLV tag stream HTTP-FLV viewers
+// see, used as ffmpeg's stdin for a rendition's transcode.
+type FLVSource interface {
+	Subscribe() (ch chan []byte, gop [][]byte, unsubscribe func())
+}
+
+type renditionProc struct {
+	cmd          *exec.Cmd
+	stdin        io.WriteCloser
+	unsubscribe  func()
+	lastAccessed time.Time
+}
+
+// Manager starts, serves, and garbage-collects on-demand renditions for
+// every live stream key.
+type Manager struct {
+	idleTimeout time.Duration
+
+	mu    sync.Mutex
+	procs map[string]map[string]*renditionProc // key -> rendition name -> proc
+}
+
+// NewManager creates a Manager whose renditions are stopped after
+// idleTimeout without a viewer request.
+func NewManager(idleTimeout time.Duration) *Manager {
+	m := &Manager{
+		idleTimeout: idleTimeout,
+		procs:       make(map[string]map[string]*renditionProc),
+	}
+	go m.gcLoop()
+	return m
+}
+
+// Ensure starts rendition's ffmpeg transcode for key if it isn't already
+// running, and marks it as recently accessed either way.
+func (m *Manager) Ensure(key string, r Rendition, source FLVSource, outputDir string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.procs[key] == nil {
+		m.procs[key] = make(map[string]*renditionProc)
+	}
+	if p, ok := m.procs[key][r.Name]; ok {
+		p.lastAccessed = time.Now()
+		return nil
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return errors.Wrap(err, "Failed to create rendition output dir")
+	}
+
+	encoder := r.Encoder
+	if encoder == "" {
+		encoder = "libx264"
+	}
+	keyintSeconds := 2
+	ffmpegCmd := exec.Command("ffmpeg",
+		"-i", "pipe:0",
+		// -2 for the width lets ffmpeg auto-pick the nearest even dimension
+		// that preserves aspect ratio; a precomputed 16:9 width is odd for
+		// common heights (e.g. 480p -> 853), which most encoders reject.
+		"-vf", fmt.Sprintf("scale=-2:%d", r.Height),
+		"-c:v", encoder,
+		"-b:v", strconv.Itoa(r.VideoBitrateKb)+"k",
+		"-g", strconv.Itoa(keyintSeconds*30),
+		"-keyint_min", strconv.Itoa(keyintSeconds*30),
+		"-c:a", "aac",
+		"-b:a", strconv.Itoa(r.AudioBitrateKb)+"k",
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(keyintSeconds),
+		"-hls_list_size", "5",
+		"-hls_flags", "delete_segments",
+		filepath.Join(outputDir, "index.m3u8"),
+	)
+
+	stdin, err := ffmpegCmd.StdinPipe()
+	if err != nil {
+		return errors.Wrap(err, "Failed to get rendition ffmpeg stdin")
+	}
+	ffmpegCmd.Stdout = os.Stdout
+	ffmpegCmd.Stderr = os.Stderr
+
+	if err := ffmpegCmd.Start(); err != nil {
+		stdin.Close()
+		return errors.Wrap(err, "Failed to start rendition ffmpeg")
+	}
+
+	ch, gop, unsubscribe := source.Subscribe()
+	for _, tag := range gop {
+		if _, err := stdin.Write(tag); err != nil {
+			break
+		}
+	}
+	go func() {
+		for tag := range ch {
+			if _, err := stdin.Write(tag); err != nil {
+				return
+			}
+		}
+	}()
+
+	m.procs[key][r.Name] = &renditionProc{
+		cmd:          ffmpegCmd,
+		stdin:        stdin,
+		unsubscribe:  unsubscribe,
+		lastAccessed: time.Now(),
+	}
+	return nil
+}
+
+// Touch records that rendition of key was just requested, resetting its
+// idle timer without starting it if it isn't running.
+func (m *Manager) Touch(key, rendition string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p, ok := m.procs[key][rendition]; ok {
+		p.lastAccessed = time.Now()
+	}
+}
+
+// StopAll tears down every rendition transcode for key, e.g. when the
+// publish ends.
+func (m *Manager) StopAll(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, p := range m.procs[key] {
+		m.stopLocked(p)
+		delete(m.procs[key], name)
+	}
+	delete(m.procs, key)
+}
+
+func (m *Manager) stopLocked(p *renditionProc) {
+	p.unsubscribe()
+	_ = p.stdin.Close()
+	if p.cmd.Process != nil {
+		_ = p.cmd.Process.Kill()
+	}
+}
+
+func (m *Manager) gcLoop() {
+	ticker := time.NewTicker(m.idleTimeout / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.mu.Lock()
+		for key, renditions := range m.procs {
+			for name, p := range renditions {
+				if time.Since(p.lastAccessed) > m.idleTimeout {
+					m.stopLocked(p)
+					delete(renditions, name)
+				}
+			}
+			if len(renditions) == 0 {
+				delete(m.procs, key)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
@@ -0,0 +1,31 @@
+package abr
+
+import (
+	"fmt"
+	"io"
+)
+
+// WriteMasterPlaylist writes a master HLS playlist with one
+// #EXT-X-STREAM-INF per rendition plus the original un-transcoded source,
+// sourceBandwidth estimating the source's current bitrate in bits/second.
+func WriteMasterPlaylist(w io.Writer, renditions []Rendition, sourceBandwidth int64) {
+	fmt.Fprintln(w, "#EXTM3U")
+	fmt.Fprintln(w, "#EXT-X-VERSION:4")
+
+	fmt.Fprintf(w, "#EXT-X-STREAM-INF:BANDWIDTH=%d,CODECS=\"avc1.64001f,mp4a.40.2\"\n", sourceBandwidth)
+	fmt.Fprintln(w, "index.m3u8")
+
+	for _, r := range renditions {
+		bandwidth := (r.VideoBitrateKb + r.AudioBitrateKb) * 1000
+		fmt.Fprintf(w, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"avc1.64001f,mp4a.40.2\"\n", bandwidth, evenWidth(r.Height), r.Height)
+		fmt.Fprintf(w, "%s/index.m3u8\n", r.Name)
+	}
+}
+
+// evenWidth estimates the width ffmpeg's "scale=-2:height" filter (see
+// abr.go) will actually pick for a 16:9 source: nearest even integer, since
+// -2 rounds to the closest dimension divisible by 2 that most encoders
+// require.
+func evenWidth(height int) int {
+	return 2 * ((height*8 + 4) / 9)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/archroid/watchify/hls/abr"
+)
+
+// renditionStartupTimeout bounds how long a request that lazily starts a
+// rendition will wait for its ffmpeg transcode to produce a first playlist
+// before falling through to the file server, which would otherwise 404 on
+// the very request that triggered the start.
+const renditionStartupTimeout = 4 * time.Second
+
+// renditionsFor returns the configured ABR ladder for key, if any.
+func renditionsFor(cfg *Config, key string) []abr.Rendition {
+	for _, s := range cfg.Streams {
+		if s.Key != key {
+			continue
+		}
+		renditions := make([]abr.Rendition, 0, len(s.Renditions))
+		for _, r := range s.Renditions {
+			renditions = append(renditions, abr.Rendition{
+				Name:           r.Name,
+				Height:         r.Height,
+				VideoBitrateKb: r.VideoBitrateKb,
+				AudioBitrateKb: r.AudioBitrateKb,
+				Encoder:        r.Encoder,
+			})
+		}
+		return renditions
+	}
+	return nil
+}
+
+// masterPlaylistHandler serves GET /{key}/master.m3u8.
+func masterPlaylistHandler(cfg *Config, registry *StreamRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), "/master.m3u8")
+
+		session, ok := registry.Lookup(key)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		abr.WriteMasterPlaylist(w, renditionsFor(cfg, key), session.Bitrate())
+	}
+}
+
+// renditionHandler lazily starts the requested rendition's transcode (if
+// not already running) before falling through to fileServer to serve its
+// playlist/segments, which mirrors the index.m3u8/N.ts naming the source
+// rendition already uses.
+func renditionHandler(cfg *Config, registry *StreamRegistry, manager *abr.Manager, fileServer http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, renditionName, ok := splitRenditionPath(r.URL.Path)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		session, ok := registry.Lookup(key)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		var rendition *abr.Rendition
+		for _, cand := range renditionsFor(cfg, key) {
+			if cand.Name == renditionName {
+				c := cand
+				rendition = &c
+				break
+			}
+		}
+		if rendition == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		manager.Touch(key, renditionName)
+		outputDir := filepath.Join(cfg.PublicDir, filepath.Clean(key), filepath.Clean(renditionName))
+		if err := manager.Ensure(key, *rendition, session.Broadcast, outputDir); err != nil {
+			log.Printf("Failed to start rendition %s/%s: %+v", key, renditionName, err)
+			http.Error(w, "failed to start rendition", http.StatusInternalServerError)
+			return
+		}
+		waitForRenditionPlaylist(outputDir)
+
+		fileServer.ServeHTTP(w, r)
+	}
+}
+
+// waitForRenditionPlaylist polls for the rendition ffmpeg's first playlist,
+// up to renditionStartupTimeout, since manager.Ensure only starts the
+// transcode asynchronously and a lazily-started rendition otherwise has
+// nothing for fileServer to serve yet.
+func waitForRenditionPlaylist(outputDir string) {
+	deadline := time.Now().Add(renditionStartupTimeout)
+	path := filepath.Join(outputDir, "index.m3u8")
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// splitRenditionPath extracts key and rendition name from
+// /{key}/{rendition}/index.m3u8 or /{key}/{rendition}/{segment}.ts.
+func splitRenditionPath(path string) (key, rendition string, ok bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 3 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// isRenditionPath reports whether path addresses a rendition's playlist or
+// segment (/{key}/{rendition}/...) as opposed to the source stream's own
+// (/{key}/...), which the plain file server already handles.
+func isRenditionPath(path string) bool {
+	_, _, ok := splitRenditionPath(path)
+	return ok
+}
+
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+)
+
+// flvBroadcaster fans the FLV-encoded bytes produced for one publish out to
+// any number of HTTP-FLV subscribers. It keeps a GOP cache (the last
+// keyframe tag plus everything encoded since) so a subscriber joining
+// mid-stream gets a decodable picture immediately instead of waiting for
+// the next IDR frame, plus a separate header cache (onMetaData and the AAC
+// and AVC sequence header tags) that is never reset by a keyframe and is
+// always replayed ahead of the GOP, since a subscriber can't decode
+// anything without those one-time tags.
+type flvBroadcaster struct {
+	mu          sync.Mutex
+	subscribers map[chan []byte]struct{}
+	headerTags  [][]byte
+	gopCache    [][]byte
+}
+
+func newFLVBroadcaster() *flvBroadcaster {
+	return &flvBroadcaster{
+		subscribers: make(map[chan []byte]struct{}),
+	}
+}
+
+// publish fans an already FLV-encoded media tag out to every subscriber,
+// dropping slow subscribers rather than blocking the publisher. isKeyframe
+// resets the GOP cache so it never grows to hold more than one GOP. Use
+// publishHeader for onMetaData/sequence-header tags instead.
+func (b *flvBroadcaster) publish(tag []byte, isKeyframe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if isKeyframe {
+		b.gopCache = [][]byte{tag}
+	} else {
+		b.gopCache = append(b.gopCache, tag)
+	}
+
+	b.fanoutLocked(tag)
+}
+
+// publishHeader fans out and caches a one-time decoder-config tag
+// (onMetaData, or an AAC or AVC sequence header). Unlike publish, it never
+// resets or is reset by the GOP cache, so it survives every keyframe and is
+// always replayed to new subscribers ahead of the GOP.
+func (b *flvBroadcaster) publishHeader(tag []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.headerTags = append(b.headerTags, tag)
+	b.fanoutLocked(tag)
+}
+
+func (b *flvBroadcaster) fanoutLocked(tag []byte) {
+	for ch := range b.subscribers {
+		select {
+		case ch <- tag:
+		default:
+			// Subscriber isn't keeping up; drop the tag rather than stall the publisher.
+		}
+	}
+}
+
+// Subscribe registers a new FLV tag consumer (an HTTP-FLV viewer, or an ABR
+// transcoder) and returns its tag channel plus the cached header tags and
+// GOP, in replay order, so it can start decoding from the last keyframe.
+func (b *flvBroadcaster) Subscribe() (ch chan []byte, gop [][]byte, unsubscribe func()) {
+	ch = make(chan []byte, 256)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	gop = make([][]byte, 0, len(b.headerTags)+len(b.gopCache))
+	gop = append(gop, b.headerTags...)
+	gop = append(gop, b.gopCache...)
+	b.mu.Unlock()
+
+	unsubscribe = func() {
+		b.mu.Lock()
+		delete(b.subscribers, ch)
+		b.mu.Unlock()
+		close(ch)
+	}
+	return ch, gop, unsubscribe
+}
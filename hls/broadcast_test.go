@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestFLVBroadcasterSubscribeReplaysHeadersAcrossKeyframes(t *testing.T) {
+	b := newFLVBroadcaster()
+
+	metadata := []byte("onMetaData")
+	b.publishHeader(metadata)
+	avcSeqHeader := []byte("avc-seq-header")
+	b.publishHeader(avcSeqHeader)
+	aacSeqHeader := []byte("aac-seq-header")
+	b.publishHeader(aacSeqHeader)
+
+	firstIDR := []byte("idr-1")
+	b.publish(firstIDR, true)
+	interFrame := []byte("inter-1")
+	b.publish(interFrame, false)
+	secondIDR := []byte("idr-2")
+	b.publish(secondIDR, true)
+
+	_, gop, unsubscribe := b.Subscribe()
+	defer unsubscribe()
+
+	want := [][]byte{metadata, avcSeqHeader, aacSeqHeader, secondIDR}
+	if len(gop) != len(want) {
+		t.Fatalf("Subscribe() gop = %d tags, want %d: %v", len(gop), len(want), gop)
+	}
+	for i, tag := range want {
+		if string(gop[i]) != string(tag) {
+			t.Errorf("gop[%d] = %q, want %q", i, gop[i], tag)
+		}
+	}
+}
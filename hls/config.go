@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// RenditionConfig describes one ABR rendition to transcode on demand.
+type RenditionConfig struct {
+	Name           string `json:"name"`              // e.g. "720p", used in the rendition's subdirectory and master playlist
+	Height         int    `json:"height"`             // vertical resolution; width is derived assuming 16:9
+	VideoBitrateKb int    `json:"video_bitrate_kb"`   // target video bitrate ceiling, in kbit/s
+	AudioBitrateKb int    `json:"audio_bitrate_kb"`   // target audio bitrate, in kbit/s
+	Encoder        string `json:"encoder,omitempty"` // ffmpeg video encoder, e.g. libx264, h264_vaapi, h264_nvenc; defaults to libx264
+}
+
+// StreamConfig describes one allowed stream key and its per-key options.
+type StreamConfig struct {
+	Key        string            `json:"key"`
+	Name       string            `json:"name,omitempty"`
+	Renditions []RenditionConfig `json:"renditions,omitempty"`
+
+	Record                   bool   `json:"record,omitempty"`                     // tee the publish to a durable on-disk recording
+	RecordFormat             string `json:"record_format,omitempty"`              // flv, mp4, or both; defaults to flv
+	RecordDir                string `json:"record_dir,omitempty"`                 // defaults to recordings/{key}
+	RecordMaxSizeMB          int    `json:"record_max_size_mb,omitempty"`         // rotate once a segment reaches this size; 0 disables
+	RecordMaxDurationSeconds int    `json:"record_max_duration_seconds,omitempty"` // rotate once a segment has run this long; 0 disables
+}
+
+// Config is the top-level server configuration, loaded from a JSON file
+// listing the stream keys that are allowed to publish.
+type Config struct {
+	PublicDir                   string         `json:"public_dir"`
+	Streams                     []StreamConfig `json:"streams"`
+	RenditionIdleTimeoutSeconds int            `json:"rendition_idle_timeout_seconds,omitempty"`
+}
+
+// LoadConfig reads and parses the server config from path.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to open config file")
+	}
+	defer f.Close()
+
+	var cfg Config
+	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
+		return nil, errors.Wrap(err, "Failed to decode config file")
+	}
+
+	if cfg.PublicDir == "" {
+		cfg.PublicDir = "public"
+	}
+	if cfg.RenditionIdleTimeoutSeconds == 0 {
+		cfg.RenditionIdleTimeoutSeconds = 300
+	}
+
+	return &cfg, nil
+}
+
+// allowedKeys returns the set of stream keys permitted to publish.
+func (c *Config) allowedKeys() map[string]StreamConfig {
+	keys := make(map[string]StreamConfig, len(c.Streams))
+	for _, s := range c.Streams {
+		keys[s.Key] = s
+	}
+	return keys
+}
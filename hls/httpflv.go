@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// flvHeader is the 9-byte FLV file header (audio+video present) followed by
+// the 4-byte PreviousTagSize0, as required before the first FLV tag.
+var flvHeader = []byte{
+	'F', 'L', 'V', // signature
+	1,          // version
+	0x05,       // flags: audio + video
+	0, 0, 0, 9, // header size
+	0, 0, 0, 0, // PreviousTagSize0
+}
+
+// flvHandler serves GET /{key}/live.flv, an HTTP-FLV stream multiplexed
+// from the same FLV tags the RTMP handler encodes for each publish. The connection is
+// hijacked so tags can be pushed to the client as they arrive instead of
+// being buffered into a single response.
+func flvHandler(registry *StreamRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), "/live.flv")
+
+		session, ok := registry.Lookup(key)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		conn, bufrw, err := hijacker.Hijack()
+		if err != nil {
+			log.Printf("Failed to hijack connection for HTTP-FLV: %+v", err)
+			return
+		}
+		defer conn.Close()
+
+		writeFLVResponseHeaders(bufrw)
+
+		ch, gop, unsubscribe := session.Broadcast.Subscribe()
+		defer unsubscribe()
+
+		if _, err := bufrw.Write(flvHeader); err != nil {
+			return
+		}
+		for _, tag := range gop {
+			if _, err := bufrw.Write(tag); err != nil {
+				return
+			}
+		}
+		if err := bufrw.Flush(); err != nil {
+			return
+		}
+
+		for tag := range ch {
+			if _, err := bufrw.Write(tag); err != nil {
+				return
+			}
+			if err := bufrw.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeFLVResponseHeaders(bufrw *bufio.ReadWriter) {
+	fmt.Fprint(bufrw, "HTTP/1.1 200 OK\r\n")
+	fmt.Fprint(bufrw, "Content-Type: video/x-flv\r\n")
+	fmt.Fprint(bufrw, "Cache-Control: no-cache\r\n")
+	fmt.Fprint(bufrw, "Connection: close\r\n")
+	fmt.Fprint(bufrw, "\r\n")
+	bufrw.Flush()
+}
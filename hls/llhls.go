@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// blockingReloadTimeout bounds how long a ll.m3u8 request will wait for a
+// newer playlist before returning what it currently has. Real LL-HLS parts
+// would unblock as soon as a part is cut; this is a best-effort poll of the
+// playlist file the in-process segmenter maintains instead.
+const blockingReloadTimeout = 4 * time.Second
+
+// llHandler serves GET /{key}/ll.m3u8. It supports the LL-HLS blocking
+// playlist reload query param _HLS_msn by polling the segmenter-maintained
+// playlist until its media sequence catches up; _HLS_part is ignored, since
+// no partial segments are cut. It also
+// appends an EXT-X-PRELOAD-HINT for the segment the segmenter is expected
+// to cut next so compliant clients can start prefetching it.
+func llHandler(cfg *Config, registry *StreamRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), "/ll.m3u8")
+
+		if _, ok := registry.Lookup(key); !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		m3u8Path := filepath.Join(cfg.PublicDir, filepath.Clean(key), "index.m3u8")
+		wantMSN, _ := strconv.Atoi(r.URL.Query().Get("_HLS_msn"))
+
+		deadline := time.Now().Add(blockingReloadTimeout)
+		playlist, msn, err := readPlaylist(m3u8Path)
+		for err == nil && wantMSN > msn && time.Now().Before(deadline) {
+			time.Sleep(200 * time.Millisecond)
+			playlist, msn, err = readPlaylist(m3u8Path)
+		}
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		fmt.Fprint(w, playlist)
+		fmt.Fprintf(w, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%d.ts\"\n", msn+1)
+	}
+}
+
+// readPlaylist returns the playlist contents and the media sequence number
+// of the last segment it lists.
+func readPlaylist(path string) (string, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	var b strings.Builder
+	mediaSeq := 0
+	segments := 0
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		b.WriteString(line)
+		b.WriteByte('\n')
+
+		if strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:") {
+			mediaSeq, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+		}
+		if strings.HasPrefix(line, "#EXTINF:") {
+			segments++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", 0, err
+	}
+
+	return b.String(), mediaSeq + segments - 1, nil
+}
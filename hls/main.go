@@ -7,10 +7,11 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -19,9 +20,27 @@ import (
 	flvtag "github.com/yutopp/go-flv/tag"
 	"github.com/yutopp/go-rtmp"
 	rtmpmsg "github.com/yutopp/go-rtmp/message"
+
+	"github.com/archroid/watchify/hls/abr"
+	"github.com/archroid/watchify/hls/recorder"
+	"github.com/archroid/watchify/hls/rtcegress"
+	"github.com/archroid/watchify/hls/segmenter"
 )
 
+// hlsSegmentDuration mirrors the `-hls_time 2` the ffmpeg pipeline used to
+// pass; the in-process segmenter cuts a new TS segment on the first
+// keyframe once a segment has run for at least this long.
+const hlsSegmentDuration = 2 * time.Second
+
 func main() {
+	cfg, err := LoadConfig("config.json")
+	if err != nil {
+		log.Panicf("Failed: %+v", err)
+	}
+
+	registry := NewStreamRegistry(cfg)
+	abrManager := abr.NewManager(time.Duration(cfg.RenditionIdleTimeoutSeconds) * time.Second)
+
 	sigs := make(chan os.Signal, 1)                      // Buffered channel to hold one signal
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM) // Listen for Ctrl+C (SIGINT) and graceful termination (SIGTERM)
 
@@ -30,12 +49,40 @@ func main() {
 		fmt.Println("Received signal:", sig)
 		// Perform cleanup or other actions here
 		fmt.Println("Performing graceful shutdown...")
-		os.Remove("public/ali/index.m3u8")
-		os.Remove("public/ali/*.ts")
+		for _, s := range registry.List() {
+			os.RemoveAll(filepath.Join(cfg.PublicDir, filepath.Clean(s.Key)))
+		}
 		os.Exit(0) // Exit the program after handling
 	}()
 
-	http.Handle("/", http.FileServer(http.Dir("public/ali")))
+	http.Handle("/streams", streamsHandler(registry))
+	http.Handle("/recordings", recordingsListHandler(cfg))
+	http.Handle("/recordings/", recordingFileHandler(cfg))
+	fileServer := http.FileServer(http.Dir(cfg.PublicDir))
+	whepHandler := rtcegress.WHEPHandler(registry.LookupMedia)
+	whipHandler := rtcegress.WHIPHandler(func(key string) (rtcegress.Ingest, error) {
+		return newWHIPIngest(cfg, registry, abrManager, key)
+	})
+	masterHandler := masterPlaylistHandler(cfg, registry)
+	renditionsHandler := renditionHandler(cfg, registry, abrManager, fileServer)
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/live.flv"):
+			flvHandler(registry)(w, r)
+		case strings.HasSuffix(r.URL.Path, "/ll.m3u8"):
+			llHandler(cfg, registry)(w, r)
+		case strings.HasSuffix(r.URL.Path, "/whep"):
+			whepHandler(w, r)
+		case strings.HasSuffix(r.URL.Path, "/whip"):
+			whipHandler(w, r)
+		case strings.HasSuffix(r.URL.Path, "/master.m3u8"):
+			masterHandler(w, r)
+		case isRenditionPath(r.URL.Path):
+			renditionsHandler(w, r)
+		default:
+			fileServer.ServeHTTP(w, r)
+		}
+	})
 	go http.ListenAndServe(":10500", nil)
 
 	tcpAddr, err := net.ResolveTCPAddr("tcp", ":1935")
@@ -51,7 +98,7 @@ func main() {
 	srv := rtmp.NewServer(&rtmp.ServerConfig{
 		OnConnect: func(conn net.Conn) (io.ReadWriteCloser, *rtmp.ConnConfig) {
 			l := log.StandardLogger()
-			h := &Handler{}
+			h := &Handler{cfg: cfg, registry: registry, abrManager: abrManager}
 			return conn, &rtmp.ConnConfig{
 				Handler: h,
 				Logger:  l,
@@ -67,9 +114,16 @@ func main() {
 
 type Handler struct {
 	rtmp.DefaultHandler
-	ffmpegCmd *exec.Cmd
-	ffmpegIn  io.WriteCloser
-	flvEnc    *flv.Encoder
+	cfg           *Config
+	registry      *StreamRegistry
+	abrManager    *abr.Manager
+	session       *PublisherSession
+	flvEnc        *flv.Encoder
+	broadcastBuf  *bytes.Buffer
+	segmenter     *segmenter.Segmenter
+	recorder      *recorder.Recorder
+	nalLengthSize int
+	sawAVCConfig  bool
 }
 
 func (h *Handler) OnPublish(_ *rtmp.StreamContext, timestamp uint32, cmd *rtmpmsg.NetStreamPublish) error {
@@ -79,52 +133,105 @@ func (h *Handler) OnPublish(_ *rtmp.StreamContext, timestamp uint32, cmd *rtmpms
 		return errors.New("PublishingName is empty")
 	}
 
-	outputDir := filepath.Join("public", filepath.Clean(cmd.PublishingName))
-	err := os.MkdirAll(outputDir, 0755)
+	return h.startPublish(cmd.PublishingName)
+}
+
+// startPublish registers key with the stream registry and starts the
+// in-process HLS segmenter for it. It is shared by the RTMP publish path
+// and the WHIP ingest path, which both feed the same FLV encoder.
+func (h *Handler) startPublish(key string) error {
+	session, err := h.registry.Start(key)
 	if err != nil {
-		return errors.Wrap(err, "Failed to create output dir")
+		return errors.Wrap(err, "Failed to start publishing")
 	}
+	h.session = session
 
-	m3u8Path := filepath.Join(outputDir, "index.m3u8")
+	outputDir := filepath.Join(h.cfg.PublicDir, filepath.Clean(key))
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		h.registry.Stop(key)
+		return errors.Wrap(err, "Failed to create output dir")
+	}
 
-	// Start ffmpeg process
-	ffmpegCmd := exec.Command("ffmpeg",
-		"-fflags", "nobuffer",
-		"-flags", "low_delay",
-		"-i", "pipe:0",
-		"-c:v", "copy",
-		"-c:a", "aac",
-		"-f", "hls",
-		"-hls_time", "2",
-		"-hls_list_size", "5",
-		"-hls_flags", "split_by_time+delete_segments+program_date_time",
-		m3u8Path,
-	)
+	h.segmenter = segmenter.New(outputDir, hlsSegmentDuration)
 
-	ffmpegStdin, err := ffmpegCmd.StdinPipe()
+	h.broadcastBuf = new(bytes.Buffer)
+	enc, err := flv.NewEncoder(h.broadcastBuf, flv.FlagsAudio|flv.FlagsVideo)
 	if err != nil {
-		return errors.Wrap(err, "Failed to get ffmpeg stdin")
+		return errors.Wrap(err, "Failed to create FLV encoder")
+	}
+	h.flvEnc = enc
+
+	if streamCfg, ok := h.registry.Config(key); ok && streamCfg.Record {
+		rec, err := recorder.New(key, recordOptions(streamCfg))
+		if err != nil {
+			log.Printf("Failed to start recorder for %q: %+v", key, err)
+		} else {
+			h.recorder = rec
+		}
+	}
+
+	return nil
+}
+
+// recordOptions derives recorder.Options from a stream's recording config.
+func recordOptions(streamCfg StreamConfig) recorder.Options {
+	dir := streamCfg.RecordDir
+	if dir == "" {
+		dir = filepath.Join("recordings", streamCfg.Key)
 	}
+	return recorder.Options{
+		Dir:          dir,
+		Format:       streamCfg.RecordFormat,
+		MaxSizeBytes: int64(streamCfg.RecordMaxSizeMB) * 1024 * 1024,
+		MaxDuration:  time.Duration(streamCfg.RecordMaxDurationSeconds) * time.Second,
+	}
+}
 
-	ffmpegCmd.Stdout = os.Stdout
-	ffmpegCmd.Stderr = os.Stderr
+// broadcastTag drains whatever the flv.Encoder just wrote to h.broadcastBuf,
+// fans it out to HTTP-FLV subscribers of the current session, and tees it
+// to the recording, if one is running.
+func (h *Handler) broadcastTag(isKeyframe bool) {
+	tag, ok := h.drainBroadcastBuf()
+	if !ok {
+		return
+	}
+	h.session.Broadcast.publish(tag, isKeyframe)
 
-	if err := ffmpegCmd.Start(); err != nil {
-		return errors.Wrap(err, "Failed to start ffmpeg")
+	if h.recorder != nil {
+		if err := h.recorder.WriteTag(tag); err != nil {
+			log.Printf("Failed to write recording tag: %+v", err)
+		}
 	}
+}
 
-	h.ffmpegCmd = ffmpegCmd
-	h.ffmpegIn = ffmpegStdin
+// broadcastHeaderTag is broadcastTag's counterpart for onMetaData and the
+// AAC/AVC sequence header tags: it caches the tag independent of the
+// keyframe-driven GOP cache so it can be replayed ahead of every GOP, to
+// HTTP-FLV subscribers and recording segments alike.
+func (h *Handler) broadcastHeaderTag() {
+	tag, ok := h.drainBroadcastBuf()
+	if !ok {
+		return
+	}
+	h.session.Broadcast.publishHeader(tag)
 
-	enc, err := flv.NewEncoder(ffmpegStdin, flv.FlagsAudio|flv.FlagsVideo)
-	if err != nil {
-		ffmpegStdin.Close()
-		ffmpegCmd.Process.Kill()
-		return errors.Wrap(err, "Failed to create FLV encoder")
+	if h.recorder != nil {
+		if err := h.recorder.WriteHeaderTag(tag); err != nil {
+			log.Printf("Failed to write recording header tag: %+v", err)
+		}
 	}
-	h.flvEnc = enc
+}
 
-	return nil
+// drainBroadcastBuf copies out and resets whatever the flv.Encoder just
+// wrote to h.broadcastBuf.
+func (h *Handler) drainBroadcastBuf() (tag []byte, ok bool) {
+	if h.broadcastBuf.Len() == 0 {
+		return nil, false
+	}
+	tag = make([]byte, h.broadcastBuf.Len())
+	copy(tag, h.broadcastBuf.Bytes())
+	h.broadcastBuf.Reset()
+	return tag, true
 }
 
 func (h *Handler) OnSetDataFrame(timestamp uint32, data *rtmpmsg.NetStreamSetDataFrame) error {
@@ -143,6 +250,7 @@ func (h *Handler) OnSetDataFrame(timestamp uint32, data *rtmpmsg.NetStreamSetDat
 	}); err != nil {
 		log.Printf("Failed to write script data: %+v", err)
 	}
+	h.broadcastHeaderTag()
 	return nil
 }
 
@@ -156,7 +264,9 @@ func (h *Handler) OnAudio(timestamp uint32, payload io.Reader) error {
 	if _, err := io.Copy(buf, audio.Data); err != nil {
 		return err
 	}
+	rawAudio := append([]byte(nil), buf.Bytes()...)
 	audio.Data = buf
+	h.session.AddBytes(buf.Len())
 
 	if err := h.flvEnc.Encode(&flvtag.FlvTag{
 		TagType:   flvtag.TagTypeAudio,
@@ -165,6 +275,18 @@ func (h *Handler) OnAudio(timestamp uint32, payload io.Reader) error {
 	}); err != nil {
 		log.Printf("Failed to write audio: %+v", err)
 	}
+
+	switch audio.AACPacketType {
+	case flvtag.AACPacketTypeSequenceHeader:
+		h.broadcastHeaderTag()
+		h.segmenter.SetAudioConfig(rawAudio)
+	case flvtag.AACPacketTypeRaw:
+		h.broadcastTag(false)
+		h.session.Media.PublishAudio(rtcegress.Sample{Data: [][]byte{rawAudio}})
+		if err := h.segmenter.WriteAudio(time.Duration(timestamp)*time.Millisecond, rawAudio); err != nil {
+			log.Printf("Failed to segment audio: %+v", err)
+		}
+	}
 	return nil
 }
 
@@ -178,7 +300,9 @@ func (h *Handler) OnVideo(timestamp uint32, payload io.Reader) error {
 	if _, err := io.Copy(buf, video.Data); err != nil {
 		return err
 	}
+	rawVideo := append([]byte(nil), buf.Bytes()...)
 	video.Data = buf
+	h.session.AddBytes(buf.Len())
 
 	if err := h.flvEnc.Encode(&flvtag.FlvTag{
 		TagType:   flvtag.TagTypeVideo,
@@ -187,18 +311,62 @@ func (h *Handler) OnVideo(timestamp uint32, payload io.Reader) error {
 	}); err != nil {
 		log.Printf("Failed to write video: %+v", err)
 	}
+	isKeyframe := video.FrameType == flvtag.FrameTypeKeyFrame
+	if video.AVCPacketType == flvtag.AVCPacketTypeSequenceHeader {
+		// The AVC sequence header tag is itself flagged as a keyframe by the
+		// FLV spec, but it's a one-time decoder-config tag, not GOP media —
+		// route it through the header cache so it survives later GOP resets.
+		h.broadcastHeaderTag()
+	} else {
+		h.broadcastTag(isKeyframe)
+	}
+
+	switch video.AVCPacketType {
+	case flvtag.AVCPacketTypeSequenceHeader:
+		sps, pps, nalLengthSize, err := rtcegress.ParseAVCSequenceHeader(rawVideo)
+		if err != nil {
+			log.Printf("Failed to parse AVC sequence header: %+v", err)
+			break
+		}
+		h.nalLengthSize = nalLengthSize
+		h.session.Media.SetParameterSets(sps, pps)
+		if h.sawAVCConfig {
+			h.segmenter.MarkDiscontinuity()
+		}
+		h.sawAVCConfig = true
+	case flvtag.AVCPacketTypeNALU:
+		nalus, err := rtcegress.SplitAVCCNALUs(rawVideo, h.nalLengthSize)
+		if err != nil {
+			log.Printf("Failed to split AVCC NAL units: %+v", err)
+			break
+		}
+		h.session.Media.PublishVideo(rtcegress.Sample{Data: nalus, Keyframe: isKeyframe})
+		if err := h.segmenter.WriteVideo(time.Duration(timestamp)*time.Millisecond, nalus, isKeyframe); err != nil {
+			log.Printf("Failed to segment video: %+v", err)
+		}
+	}
 	return nil
 }
 
 func (h *Handler) OnClose() {
 	log.Println("Client disconnected")
 
-	if h.flvEnc != nil {
-		_ = h.ffmpegIn.Close()
+	if h.segmenter != nil {
+		if err := h.segmenter.Close(); err != nil {
+			log.Printf("Failed to close segmenter: %+v", err)
+		}
 	}
 
-	if h.ffmpegCmd != nil && h.ffmpegCmd.Process != nil {
-		_ = h.ffmpegCmd.Process.Kill()
+	if h.recorder != nil {
+		if err := h.recorder.Close(); err != nil {
+			log.Printf("Failed to close recorder: %+v", err)
+		}
 	}
 
+	if h.session != nil {
+		h.registry.Stop(h.session.Key)
+		if h.abrManager != nil {
+			h.abrManager.StopAll(h.session.Key)
+		}
+	}
 }
@@ -0,0 +1,144 @@
+package recorder
+
+import (
+	"os"
+
+	"github.com/abema/go-mp4"
+	"github.com/pkg/errors"
+	"github.com/yutopp/go-flv"
+	flvtag "github.com/yutopp/go-flv/tag"
+
+	"github.com/archroid/watchify/hls/rtcegress"
+)
+
+const (
+	videoTrackID = 1
+	audioTrackID = 2
+	timescale    = 90000 // 90kHz, matching the PTS units the live TS segmenter already uses
+)
+
+type fmp4Sample struct {
+	data     []byte
+	duration uint32 // in timescale units
+	keyframe bool
+}
+
+// remuxToFMP4 reads a completed FLV recording segment and writes an
+// equivalent fragmented MP4 (one moov/mvex init segment followed by a
+// single moof/mdat fragment holding every sample), so archived recordings
+// can be played back or edited with ordinary MP4 tooling.
+func remuxToFMP4(flvPath, mp4Path string) error {
+	in, err := os.Open(flvPath)
+	if err != nil {
+		return errors.Wrap(err, "Failed to open FLV recording")
+	}
+	defer in.Close()
+
+	dec, err := flv.NewDecoder(in)
+	if err != nil {
+		return errors.Wrap(err, "Failed to open FLV decoder")
+	}
+
+	var sps, pps []byte
+	nalLengthSize := 4
+	var videoSamples, audioSamples []fmp4Sample
+	var lastVideoPTS, lastAudioPTS int64
+	haveVideoPTS, haveAudioPTS := false, false
+
+	for {
+		var t flvtag.FlvTag
+		if err := dec.Decode(&t); err != nil {
+			break // EOF, or a truncated trailing tag from a crash mid-write
+		}
+
+		switch data := t.Data.(type) {
+		case *flvtag.VideoData:
+			raw, err := readAll(data.Data)
+			if err != nil {
+				return errors.Wrap(err, "Failed to read video tag")
+			}
+			pts := int64(t.Timestamp) * timescale / 1000
+			if data.AVCPacketType == flvtag.AVCPacketTypeSequenceHeader {
+				sps, pps, nalLengthSize, err = rtcegress.ParseAVCSequenceHeader(raw)
+				if err != nil {
+					return errors.Wrap(err, "Failed to parse AVC sequence header")
+				}
+				continue
+			}
+			if data.AVCPacketType != flvtag.AVCPacketTypeNALU {
+				continue
+			}
+			nalus, err := rtcegress.SplitAVCCNALUs(raw, nalLengthSize)
+			if err != nil {
+				return errors.Wrap(err, "Failed to split AVCC NAL units")
+			}
+			if haveVideoPTS && len(videoSamples) > 0 {
+				videoSamples[len(videoSamples)-1].duration = uint32(pts - lastVideoPTS)
+			}
+			videoSamples = append(videoSamples, fmp4Sample{
+				data:     toAVCCLength4(nalus),
+				keyframe: data.FrameType == flvtag.FrameTypeKeyFrame,
+			})
+			lastVideoPTS, haveVideoPTS = pts, true
+
+		case *flvtag.AudioData:
+			raw, err := readAll(data.Data)
+			if err != nil {
+				return errors.Wrap(err, "Failed to read audio tag")
+			}
+			if data.AACPacketType != flvtag.AACPacketTypeRaw {
+				continue
+			}
+			pts := int64(t.Timestamp) * timescale / 1000
+			if haveAudioPTS && len(audioSamples) > 0 {
+				audioSamples[len(audioSamples)-1].duration = uint32(pts - lastAudioPTS)
+			}
+			audioSamples = append(audioSamples, fmp4Sample{data: raw, keyframe: true})
+			lastAudioPTS, haveAudioPTS = pts, true
+		}
+	}
+
+	if len(videoSamples) > 0 {
+		videoSamples[len(videoSamples)-1].duration = timescale / 30
+	}
+	if len(audioSamples) > 0 {
+		audioSamples[len(audioSamples)-1].duration = 1024 * timescale / 44100 // one 1024-sample AAC frame at the 44.1kHz the mp4a entry declares
+	}
+
+	out, err := os.Create(mp4Path)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create MP4 recording")
+	}
+	defer out.Close()
+
+	w := mp4.NewWriter(out)
+	return writeFMP4(w, sps, pps, videoSamples, audioSamples)
+}
+
+func readAll(r interface{ Read([]byte) (int, error) }) ([]byte, error) {
+	var buf []byte
+	chunk := make([]byte, 4096)
+	for {
+		n, err := r.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		if err != nil {
+			return buf, nil
+		}
+	}
+}
+
+// toAVCCLength4 re-frames NAL units with 4-byte length prefixes, the
+// length size the avcC box written by writeAvc1 declares.
+func toAVCCLength4(nalus [][]byte) []byte {
+	var out []byte
+	for _, nalu := range nalus {
+		var length [4]byte
+		length[0] = byte(len(nalu) >> 24)
+		length[1] = byte(len(nalu) >> 16)
+		length[2] = byte(len(nalu) >> 8)
+		length[3] = byte(len(nalu))
+		out = append(out, length[:]...)
+		out = append(out, nalu...)
+	}
+	return out
+}
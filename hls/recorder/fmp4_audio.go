@@ -0,0 +1,316 @@
+package recorder
+
+import (
+	"io"
+
+	"github.com/abema/go-mp4"
+	"github.com/pkg/errors"
+)
+
+// seekBuffer is a minimal growable byte buffer implementing io.WriteSeeker,
+// since mp4.NewWriter requires seeking (to patch box sizes after the fact)
+// and bytes.Buffer doesn't support it.
+type seekBuffer struct {
+	buf []byte
+	pos int64
+}
+
+func (b *seekBuffer) Write(p []byte) (int, error) {
+	end := b.pos + int64(len(p))
+	if end > int64(len(b.buf)) {
+		grown := make([]byte, end)
+		copy(grown, b.buf)
+		b.buf = grown
+	}
+	n := copy(b.buf[b.pos:end], p)
+	b.pos = end
+	return n, nil
+}
+
+func (b *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = b.pos + offset
+	case io.SeekEnd:
+		pos = int64(len(b.buf)) + offset
+	default:
+		return 0, errors.Errorf("seekBuffer: invalid whence %d", whence)
+	}
+	if pos < 0 {
+		return 0, errors.New("seekBuffer: negative position")
+	}
+	b.pos = pos
+	return pos, nil
+}
+
+func (b *seekBuffer) Len() int {
+	return len(b.buf)
+}
+
+func writeAudioTrak(w *mp4.Writer, audio []fmp4Sample, duration uint32) error {
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeTrak()}); err != nil {
+		return err
+	}
+
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeTkhd()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.Tkhd{
+		FullBox:    mp4.FullBox{Flags: [3]byte{0, 0, 3}},
+		TrackID:    audioTrackID,
+		DurationV0: duration,
+		Volume:     0x0100,
+		Matrix:     [9]int32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000},
+	}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil {
+		return err
+	}
+
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMdia()}); err != nil {
+		return err
+	}
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMdhd()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.Mdhd{
+		Timescale:  timescale,
+		DurationV0: duration,
+		Language:   [3]byte{'u', 'n', 'd'},
+	}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil {
+		return err
+	}
+
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeHdlr()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.Hdlr{
+		HandlerType: [4]byte{'s', 'o', 'u', 'n'},
+		Name:        "SoundHandler",
+	}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil {
+		return err
+	}
+
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMinf()}); err != nil {
+		return err
+	}
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeSmhd()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.Smhd{}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil {
+		return err
+	}
+	if err := writeDinf(w); err != nil {
+		return err
+	}
+
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeStbl()}); err != nil {
+		return err
+	}
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeStsd()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.Stsd{EntryCount: 1}, mp4.Context{}); err != nil {
+		return err
+	}
+	if err := writeMp4a(w); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil { // stsd
+		return err
+	}
+	if err := writeEmptySampleTables(w); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil { // stbl
+		return err
+	}
+
+	if _, err := w.EndBox(); err != nil { // minf
+		return err
+	}
+	if _, err := w.EndBox(); err != nil { // mdia
+		return err
+	}
+	_, err := w.EndBox() // trak
+	return err
+}
+
+func writeMp4a(w *mp4.Writer) error {
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMp4a()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.AudioSampleEntry{
+		SampleEntry:   mp4.SampleEntry{DataReferenceIndex: 1},
+		ChannelCount:  2,
+		SampleSize:    16,
+		SampleRate:    44100 << 16,
+	}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeEsds()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.Esds{
+		Descriptors: []mp4.Descriptor{
+			{
+				Tag:  mp4.ESDescrTag,
+				Size: 0,
+				ESDescriptor: &mp4.ESDescriptor{
+					ESID: audioTrackID,
+				},
+			},
+		},
+	}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil { // esds
+		return err
+	}
+	_, err := w.EndBox() // mp4a
+	return err
+}
+
+// writeMoofAndMdat writes a single movie fragment holding every sample
+// from the recording segment, with each track's trun giving per-sample
+// size/duration/flags and a data-offset pointing into the mdat that
+// immediately follows. The trun data-offset (relative to the start of
+// moof) depends on moof's own size, so the fragment is built twice: once
+// into a throwaway buffer just to measure it, then for real once the
+// offsets are known.
+func writeMoofAndMdat(w *mp4.Writer, video, audio []fmp4Sample) error {
+	var sizer seekBuffer
+	if err := writeMoof(mp4.NewWriter(&sizer), video, audio, 0, 0); err != nil {
+		return err
+	}
+	moofSize := int32(sizer.Len())
+
+	videoDataOffset := moofSize + 8 // + mdat's box header
+	audioDataOffset := videoDataOffset + sumSampleSizes(video)
+
+	if err := writeMoof(w, video, audio, videoDataOffset, audioDataOffset); err != nil {
+		return err
+	}
+
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMdat()}); err != nil {
+		return err
+	}
+	for _, s := range video {
+		if _, err := w.Write(s.data); err != nil {
+			return err
+		}
+	}
+	for _, s := range audio {
+		if _, err := w.Write(s.data); err != nil {
+			return err
+		}
+	}
+	_, err := w.EndBox() // mdat
+	return err
+}
+
+func writeMoof(w *mp4.Writer, video, audio []fmp4Sample, videoDataOffset, audioDataOffset int32) error {
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMoof()}); err != nil {
+		return err
+	}
+
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMfhd()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.Mfhd{SequenceNumber: 1}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil {
+		return err
+	}
+
+	if err := writeTraf(w, videoTrackID, video, videoDataOffset); err != nil {
+		return err
+	}
+	if err := writeTraf(w, audioTrackID, audio, audioDataOffset); err != nil {
+		return err
+	}
+
+	_, err := w.EndBox() // moof
+	return err
+}
+
+func writeTraf(w *mp4.Writer, trackID uint32, samples []fmp4Sample, dataOffset int32) error {
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeTraf()}); err != nil {
+		return err
+	}
+
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeTfhd()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.Tfhd{
+		FullBox: mp4.FullBox{Flags: [3]byte{0x02, 0, 0}}, // default-base-is-moof
+		TrackID: trackID,
+	}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil {
+		return err
+	}
+
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeTfdt()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.Tfdt{BaseMediaDecodeTimeV0: 0}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil {
+		return err
+	}
+
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeTrun()}); err != nil {
+		return err
+	}
+	entries := make([]mp4.TrunEntry, 0, len(samples))
+	for _, s := range samples {
+		flags := uint32(0)
+		if !s.keyframe {
+			flags = 1 << 16 // sample_is_non_sync_sample
+		}
+		entries = append(entries, mp4.TrunEntry{
+			SampleDuration: s.duration,
+			SampleSize:     uint32(len(s.data)),
+			SampleFlags:    flags,
+		})
+	}
+	if _, err := mp4.Marshal(w, &mp4.Trun{
+		FullBox:     mp4.FullBox{Flags: [3]byte{0, 0x07, 0x01}}, // data-offset + per-sample duration, size and flags present
+		SampleCount: uint32(len(entries)),
+		DataOffset:  dataOffset,
+		Entries:     entries,
+	}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil { // trun
+		return err
+	}
+
+	_, err := w.EndBox() // traf
+	return err
+}
+
+func sumSampleSizes(samples []fmp4Sample) int32 {
+	var total int32
+	for _, s := range samples {
+		total += int32(len(s.data))
+	}
+	return total
+}
@@ -0,0 +1,311 @@
+package recorder
+
+import (
+	"github.com/abema/go-mp4"
+	"github.com/pkg/errors"
+)
+
+// writeFMP4 writes a minimal CMAF-style fragmented MP4: an ftyp, a moov
+// describing the two tracks with an empty (fragmented) sample table plus
+// mvex, and a single moof/mdat fragment carrying every sample from the
+// recording. Real-time live output uses the TS segmenter instead; this
+// only has to produce one file per completed recording segment.
+func writeFMP4(w *mp4.Writer, sps, pps []byte, video, audio []fmp4Sample) error {
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeFtyp()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.Ftyp{
+		MajorBrand:   mp4.BrandISOM(),
+		MinorVersion: 0x200,
+		CompatibleBrands: []mp4.CompatibleBrandElem{
+			{CompatibleBrand: mp4.BrandISOM()},
+			{CompatibleBrand: mp4.BrandISO5()},
+			{CompatibleBrand: mp4.BrandMP41()},
+		},
+	}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil {
+		return err
+	}
+
+	if err := writeMoov(w, sps, pps, video, audio); err != nil {
+		return errors.Wrap(err, "Failed to write moov")
+	}
+	if err := writeMoofAndMdat(w, video, audio); err != nil {
+		return errors.Wrap(err, "Failed to write moof/mdat")
+	}
+	return nil
+}
+
+func writeMoov(w *mp4.Writer, sps, pps []byte, video, audio []fmp4Sample) error {
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMoov()}); err != nil {
+		return err
+	}
+
+	duration := uint32(0)
+	for _, s := range video {
+		duration += s.duration
+	}
+
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMvhd()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.Mvhd{
+		Timescale:   timescale,
+		DurationV0:  duration,
+		Rate:        0x00010000,
+		Volume:      0x0100,
+		NextTrackID: audioTrackID + 1,
+	}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil {
+		return err
+	}
+
+	if err := writeVideoTrak(w, sps, pps, duration); err != nil {
+		return err
+	}
+	if err := writeAudioTrak(w, audio, duration); err != nil {
+		return err
+	}
+
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMvex()}); err != nil {
+		return err
+	}
+	for _, trackID := range []uint32{videoTrackID, audioTrackID} {
+		if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeTrex()}); err != nil {
+			return err
+		}
+		if _, err := mp4.Marshal(w, &mp4.Trex{
+			TrackID:                       trackID,
+			DefaultSampleDescriptionIndex: 1,
+		}, mp4.Context{}); err != nil {
+			return err
+		}
+		if _, err := w.EndBox(); err != nil {
+			return err
+		}
+	}
+	_, err := w.EndBox() // mvex
+	if err != nil {
+		return err
+	}
+
+	_, err = w.EndBox() // moov
+	return err
+}
+
+func writeVideoTrak(w *mp4.Writer, sps, pps []byte, duration uint32) error {
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeTrak()}); err != nil {
+		return err
+	}
+
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeTkhd()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.Tkhd{
+		FullBox:    mp4.FullBox{Flags: [3]byte{0, 0, 3}},
+		TrackID:    videoTrackID,
+		DurationV0: duration,
+		Matrix:     [9]int32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000},
+	}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil {
+		return err
+	}
+
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMdia()}); err != nil {
+		return err
+	}
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMdhd()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.Mdhd{
+		Timescale:  timescale,
+		DurationV0: duration,
+		Language:   [3]byte{'u', 'n', 'd'},
+	}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil {
+		return err
+	}
+
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeHdlr()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.Hdlr{
+		HandlerType: [4]byte{'v', 'i', 'd', 'e'},
+		Name:        "VideoHandler",
+	}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil {
+		return err
+	}
+
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMinf()}); err != nil {
+		return err
+	}
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeVmhd()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.Vmhd{FullBox: mp4.FullBox{Flags: [3]byte{0, 0, 1}}}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil {
+		return err
+	}
+	if err := writeDinf(w); err != nil {
+		return err
+	}
+
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeStbl()}); err != nil {
+		return err
+	}
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeStsd()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.Stsd{EntryCount: 1}, mp4.Context{}); err != nil {
+		return err
+	}
+	if err := writeAvc1(w, sps, pps); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil { // stsd
+		return err
+	}
+	if err := writeEmptySampleTables(w); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil { // stbl
+		return err
+	}
+
+	if _, err := w.EndBox(); err != nil { // minf
+		return err
+	}
+	if _, err := w.EndBox(); err != nil { // mdia
+		return err
+	}
+	_, err := w.EndBox() // trak
+	return err
+}
+
+func writeAvc1(w *mp4.Writer, sps, pps []byte) error {
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeAvc1()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.VisualSampleEntry{
+		SampleEntry:        mp4.SampleEntry{DataReferenceIndex: 1},
+		Width:              0,
+		Height:             0,
+		Horizresolution:    0x00480000,
+		Vertresolution:     0x00480000,
+		FrameCount:         1,
+		Depth:              0x0018,
+		PreDefined3:        -1,
+	}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeAvcC()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.AVCDecoderConfiguration{
+		ConfigurationVersion:       1,
+		Profile:                    spsProfile(sps),
+		ProfileCompatibility:       spsProfileCompat(sps),
+		Level:                      spsLevel(sps),
+		LengthSizeMinusOne:         3,
+		NumOfSequenceParameterSets: 1,
+		SequenceParameterSets: []mp4.AVCParameterSet{
+			{Length: uint16(len(sps)), NALUnit: sps},
+		},
+		NumOfPictureParameterSets: 1,
+		PictureParameterSets: []mp4.AVCParameterSet{
+			{Length: uint16(len(pps)), NALUnit: pps},
+		},
+	}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil { // avcC
+		return err
+	}
+	_, err := w.EndBox() // avc1
+	return err
+}
+
+func writeDinf(w *mp4.Writer) error {
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeDinf()}); err != nil {
+		return err
+	}
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeDref()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.Dref{EntryCount: 1}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeUrl()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.Url{FullBox: mp4.FullBox{Flags: [3]byte{0, 0, 1}}}, mp4.Context{}); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil { // url
+		return err
+	}
+	if _, err := w.EndBox(); err != nil { // dref
+		return err
+	}
+	_, err := w.EndBox() // dinf
+	return err
+}
+
+// writeEmptySampleTables writes the zero-entry stts/stsc/stsz/stco a
+// fragmented track's moov is required to carry; the real sample layout
+// lives in the moof/traf that follows.
+func writeEmptySampleTables(w *mp4.Writer) error {
+	for _, boxType := range []func() mp4.BoxType{mp4.BoxTypeStts, mp4.BoxTypeStsc, mp4.BoxTypeStco} {
+		if _, err := w.StartBox(&mp4.BoxInfo{Type: boxType()}); err != nil {
+			return err
+		}
+		if _, err := mp4.Marshal(w, &mp4.Stts{}, mp4.Context{}); err != nil {
+			return err
+		}
+		if _, err := w.EndBox(); err != nil {
+			return err
+		}
+	}
+	if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeStsz()}); err != nil {
+		return err
+	}
+	if _, err := mp4.Marshal(w, &mp4.Stsz{}, mp4.Context{}); err != nil {
+		return err
+	}
+	_, err := w.EndBox()
+	return err
+}
+
+func spsProfile(sps []byte) uint8 {
+	if len(sps) > 1 {
+		return sps[1]
+	}
+	return 0x64
+}
+
+func spsProfileCompat(sps []byte) uint8 {
+	if len(sps) > 2 {
+		return sps[2]
+	}
+	return 0
+}
+
+func spsLevel(sps []byte) uint8 {
+	if len(sps) > 3 {
+		return sps[3]
+	}
+	return 0x1f
+}
@@ -0,0 +1,192 @@
+// Package recorder tees a publish's FLV tag stream to durable on-disk
+// segments so a crash doesn't lose the whole broadcast the way losing the
+// ephemeral HLS segments would, rotating by size or duration and, once a
+// segment is complete, optionally remuxing it into a fragmented MP4
+// archive via abema/go-mp4.
+package recorder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// flvHeader is the 9-byte FLV file header (audio+video present) followed
+// by the 4-byte PreviousTagSize0, mirroring the one httpflv.go writes
+// ahead of a live HTTP-FLV response.
+var flvHeader = []byte{
+	'F', 'L', 'V', // signature
+	1,          // version
+	0x05,       // flags: audio + video
+	0, 0, 0, 9, // header size
+	0, 0, 0, 0, // PreviousTagSize0
+}
+
+// Options configures one stream key's recordings.
+type Options struct {
+	Dir          string        // directory recordings for this key are written into
+	Format       string        // "flv", "mp4", or "both"; defaults to "flv"
+	MaxSizeBytes int64         // rotate once a segment reaches this size; 0 disables
+	MaxDuration  time.Duration // rotate once a segment has run this long; 0 disables
+}
+
+// Recorder tees one publish's FLV tags to rotating on-disk segments.
+type Recorder struct {
+	key  string
+	opts Options
+
+	mu           sync.Mutex
+	file         *os.File
+	flvPath      string
+	bytesWritten int64
+	segmentStart time.Time
+	index        int
+	headerTags   [][]byte // onMetaData + AAC/AVC sequence headers, replayed into every new segment
+}
+
+// New creates a Recorder for key, opening its first segment.
+func New(key string, opts Options) (*Recorder, error) {
+	if opts.Format == "" {
+		opts.Format = "flv"
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "Failed to create recording dir")
+	}
+
+	r := &Recorder{key: key, opts: opts}
+	if err := r.openSegmentLocked(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// WriteTag tees one already-encoded FLV tag, identical to what HTTP-FLV
+// viewers receive, to the current segment, rotating first if needed.
+func (r *Recorder) WriteTag(tag []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.shouldRotateLocked() {
+		if err := r.rotateLocked(); err != nil {
+			return err
+		}
+	}
+
+	n, err := r.file.Write(tag)
+	r.bytesWritten += int64(n)
+	if err != nil {
+		return errors.Wrap(err, "Failed to write recording tag")
+	}
+	return nil
+}
+
+// WriteHeaderTag tees a one-time decoder-config tag (onMetaData, or an AAC
+// or AVC sequence header), identical to what HTTP-FLV viewers receive, to
+// the current segment, and caches it so it's replayed into every later
+// segment a rotation opens, not just the first.
+func (r *Recorder) WriteHeaderTag(tag []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.headerTags = append(r.headerTags, tag)
+
+	if r.shouldRotateLocked() {
+		if err := r.rotateLocked(); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	n, err := r.file.Write(tag)
+	r.bytesWritten += int64(n)
+	if err != nil {
+		return errors.Wrap(err, "Failed to write recording header tag")
+	}
+	return nil
+}
+
+// Close finalizes whatever segment is currently open.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closeSegmentLocked()
+}
+
+func (r *Recorder) shouldRotateLocked() bool {
+	if r.opts.MaxSizeBytes > 0 && r.bytesWritten >= r.opts.MaxSizeBytes {
+		return true
+	}
+	if r.opts.MaxDuration > 0 && time.Since(r.segmentStart) >= r.opts.MaxDuration {
+		return true
+	}
+	return false
+}
+
+func (r *Recorder) rotateLocked() error {
+	if err := r.closeSegmentLocked(); err != nil {
+		return err
+	}
+	r.index++
+	return r.openSegmentLocked()
+}
+
+func (r *Recorder) openSegmentLocked() error {
+	name := fmt.Sprintf("%s-%d-%03d.flv", r.key, time.Now().Unix(), r.index)
+	path := filepath.Join(r.opts.Dir, name)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create recording segment")
+	}
+	if _, err := f.Write(flvHeader); err != nil {
+		f.Close()
+		return errors.Wrap(err, "Failed to write recording FLV header")
+	}
+
+	r.file = f
+	r.flvPath = path
+	r.bytesWritten = int64(len(flvHeader))
+	r.segmentStart = time.Now()
+
+	for _, tag := range r.headerTags {
+		n, err := f.Write(tag)
+		r.bytesWritten += int64(n)
+		if err != nil {
+			return errors.Wrap(err, "Failed to replay recording header tags")
+		}
+	}
+	return nil
+}
+
+func (r *Recorder) closeSegmentLocked() error {
+	if r.file == nil {
+		return nil
+	}
+
+	path := r.flvPath
+	err := r.file.Close()
+	r.file = nil
+	if err != nil {
+		return errors.Wrap(err, "Failed to close recording segment")
+	}
+
+	if r.opts.Format == "flv" {
+		return nil
+	}
+
+	mp4Path := strings.TrimSuffix(path, filepath.Ext(path)) + ".mp4"
+	if err := remuxToFMP4(path, mp4Path); err != nil {
+		return errors.Wrap(err, "Failed to remux recording to fMP4")
+	}
+	if r.opts.Format == "mp4" {
+		if err := os.Remove(path); err != nil {
+			return errors.Wrap(err, "Failed to remove remuxed FLV recording")
+		}
+	}
+	return nil
+}
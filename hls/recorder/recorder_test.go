@@ -0,0 +1,52 @@
+package recorder
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecorderReplaysHeaderTagsAcrossRotation(t *testing.T) {
+	dir := t.TempDir()
+	r, err := New("test", Options{Dir: dir, Format: "flv", MaxSizeBytes: int64(len(flvHeader)) + 1})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	onMetaData := []byte("onMetaData-tag")
+	if err := r.WriteHeaderTag(onMetaData); err != nil {
+		t.Fatalf("WriteHeaderTag() error = %v", err)
+	}
+
+	// The header tag already pushed bytesWritten past MaxSizeBytes, and every
+	// segment starts past it once the header is replayed, so each WriteTag
+	// call below rotates to a new segment first.
+	if err := r.WriteTag([]byte("media-tag-1")); err != nil {
+		t.Fatalf("WriteTag() error = %v", err)
+	}
+	if err := r.WriteTag([]byte("media-tag-2")); err != nil {
+		t.Fatalf("WriteTag() error = %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) < 2 {
+		t.Fatalf("got %d segment files, want at least 2 (no rotation happened)", len(entries))
+	}
+
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatalf("ReadFile(%s) error = %v", e.Name(), err)
+		}
+		if !bytes.Contains(data, onMetaData) {
+			t.Errorf("segment %s missing replayed header tag %q", e.Name(), onMetaData)
+		}
+	}
+}
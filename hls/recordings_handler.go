@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// recordingView is the JSON representation of one archived recording
+// segment returned by the /recordings endpoint.
+type recordingView struct {
+	Key       string `json:"key"`
+	Name      string `json:"name"`
+	SizeBytes int64  `json:"sizeBytes"`
+	ModTime   string `json:"modTime"`
+}
+
+// recordingsListHandler serves GET /recordings, listing every archived
+// recording segment across all stream keys with recording enabled.
+func recordingsListHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var recordings []recordingView
+		for _, s := range cfg.Streams {
+			if !s.Record {
+				continue
+			}
+			dir := recordOptions(s).Dir
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				continue
+			}
+			for _, e := range entries {
+				if e.IsDir() {
+					continue
+				}
+				info, err := e.Info()
+				if err != nil {
+					continue
+				}
+				recordings = append(recordings, recordingView{
+					Key:       s.Key,
+					Name:      e.Name(),
+					SizeBytes: info.Size(),
+					ModTime:   info.ModTime().Format("2006-01-02T15:04:05Z07:00"),
+				})
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Recordings []recordingView `json:"recordings"`
+		}{Recordings: recordings})
+	}
+}
+
+// recordingFileHandler serves GET /recordings/{key}/{filename}, streaming
+// one archived recording segment listed by recordingsListHandler.
+func recordingFileHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, name, ok := splitRecordingPath(r.URL.Path)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		var streamCfg StreamConfig
+		found := false
+		for _, s := range cfg.Streams {
+			if s.Key == key && s.Record {
+				streamCfg = s
+				found = true
+				break
+			}
+		}
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+
+		dir := recordOptions(streamCfg).Dir
+		http.ServeFile(w, r, filepath.Join(dir, name))
+	}
+}
+
+// splitRecordingPath extracts key and filename from
+// /recordings/{key}/{filename}, rejecting anything that isn't exactly a
+// flat two-segment path so callers can't escape the recording's directory.
+func splitRecordingPath(path string) (key, name string, ok bool) {
+	trimmed := strings.TrimPrefix(strings.TrimPrefix(path, "/"), "recordings/")
+	parts := strings.Split(trimmed, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || parts[1] == ".." {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
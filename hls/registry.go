@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/archroid/watchify/hls/rtcegress"
+)
+
+// PublisherSession tracks a single live publish for a stream key.
+type PublisherSession struct {
+	Key       string
+	ID        string
+	StartedAt time.Time
+	Broadcast *flvBroadcaster
+	Media     *rtcegress.MediaBroadcaster
+
+	bytesWritten int64
+}
+
+// AddBytes records n bytes written for this session, used to derive a
+// rough bitrate estimate.
+func (s *PublisherSession) AddBytes(n int) {
+	atomic.AddInt64(&s.bytesWritten, int64(n))
+}
+
+// Bitrate returns the average bits-per-second seen since the session started.
+func (s *PublisherSession) Bitrate() int64 {
+	elapsed := time.Since(s.StartedAt).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(atomic.LoadInt64(&s.bytesWritten)*8) / elapsed)
+}
+
+// StreamRegistry tracks which stream keys are allowed to publish and which
+// of them currently have a live publisher.
+type StreamRegistry struct {
+	mu      sync.Mutex
+	allowed map[string]StreamConfig
+	live    map[string]*PublisherSession
+	nextID  int64
+}
+
+// NewStreamRegistry builds a registry from the allowed stream keys in cfg.
+func NewStreamRegistry(cfg *Config) *StreamRegistry {
+	return &StreamRegistry{
+		allowed: cfg.allowedKeys(),
+		live:    make(map[string]*PublisherSession),
+	}
+}
+
+// Start begins a publishing session for key, failing if the key is unknown
+// or already publishing.
+func (r *StreamRegistry) Start(key string) (*PublisherSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.allowed[key]; !ok {
+		return nil, errors.Errorf("unknown stream key %q", key)
+	}
+
+	if _, ok := r.live[key]; ok {
+		return nil, errors.New("stream already running; can not overtake")
+	}
+
+	r.nextID++
+	session := &PublisherSession{
+		Key:       key,
+		ID:        strconv.FormatInt(r.nextID, 10),
+		StartedAt: time.Now(),
+		Broadcast: newFLVBroadcaster(),
+		Media:     rtcegress.NewMediaBroadcaster(),
+	}
+	r.live[key] = session
+
+	return session, nil
+}
+
+// Config returns the configured options for key, if it is an allowed
+// stream key.
+func (r *StreamRegistry) Config(key string) (StreamConfig, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cfg, ok := r.allowed[key]
+	return cfg, ok
+}
+
+// Lookup returns the live publishing session for key, if any.
+func (r *StreamRegistry) Lookup(key string) (*PublisherSession, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.live[key]
+	return s, ok
+}
+
+// LookupMedia returns the WebRTC media broadcaster for key, if it is live.
+func (r *StreamRegistry) LookupMedia(key string) (*rtcegress.MediaBroadcaster, bool) {
+	s, ok := r.Lookup(key)
+	if !ok {
+		return nil, false
+	}
+	return s.Media, true
+}
+
+// Stop ends the publishing session for key, if any.
+func (r *StreamRegistry) Stop(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.live, key)
+}
+
+// List returns a snapshot of all currently live sessions.
+func (r *StreamRegistry) List() []*PublisherSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sessions := make([]*PublisherSession, 0, len(r.live))
+	for _, s := range r.live {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
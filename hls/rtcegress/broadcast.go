@@ -0,0 +1,96 @@
+// Package rtcegress forwards the H.264/AAC media already parsed out of the
+// incoming FLV tags to WebRTC viewers (WHEP) and accepts a WebRTC publisher
+// (WHIP) on the ingest side.
+package rtcegress
+
+import (
+	"sync"
+	"time"
+)
+
+// Sample is one decoded access unit (a full set of H.264 NAL units, or one
+// AAC raw frame) ready to be handed to a WebRTC track.
+type Sample struct {
+	Data     [][]byte // NAL units for video; a single raw AAC frame for audio
+	Duration time.Duration
+	Keyframe bool
+}
+
+// MediaBroadcaster fans decoded video/audio samples for one publish out to
+// any number of WHEP viewers.
+type MediaBroadcaster struct {
+	mu        sync.Mutex
+	videoSubs map[chan Sample]struct{}
+	audioSubs map[chan Sample]struct{}
+	sps, pps  []byte
+}
+
+// NewMediaBroadcaster creates an empty broadcaster for one publish session.
+func NewMediaBroadcaster() *MediaBroadcaster {
+	return &MediaBroadcaster{
+		videoSubs: make(map[chan Sample]struct{}),
+		audioSubs: make(map[chan Sample]struct{}),
+	}
+}
+
+// SetParameterSets records the SPS/PPS extracted from the AVC sequence
+// header so new WHEP subscribers can be handed them before the next IDR.
+func (b *MediaBroadcaster) SetParameterSets(sps, pps []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sps, b.pps = sps, pps
+}
+
+// PublishVideo fans a video access unit out to video subscribers.
+func (b *MediaBroadcaster) PublishVideo(s Sample) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.videoSubs {
+		select {
+		case ch <- s:
+		default:
+		}
+	}
+}
+
+// PublishAudio fans an AAC frame out to audio subscribers.
+func (b *MediaBroadcaster) PublishAudio(s Sample) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.audioSubs {
+		select {
+		case ch <- s:
+		default:
+		}
+	}
+}
+
+// SubscribeVideo registers a WHEP viewer's video channel and returns the
+// cached SPS/PPS so its decoder can be configured before the first sample.
+func (b *MediaBroadcaster) SubscribeVideo() (ch chan Sample, sps, pps []byte, unsubscribe func()) {
+	ch = make(chan Sample, 64)
+	b.mu.Lock()
+	b.videoSubs[ch] = struct{}{}
+	sps, pps = b.sps, b.pps
+	b.mu.Unlock()
+	return ch, sps, pps, func() {
+		b.mu.Lock()
+		delete(b.videoSubs, ch)
+		b.mu.Unlock()
+		close(ch)
+	}
+}
+
+// SubscribeAudio registers a WHEP viewer's audio channel.
+func (b *MediaBroadcaster) SubscribeAudio() (ch chan Sample, unsubscribe func()) {
+	ch = make(chan Sample, 64)
+	b.mu.Lock()
+	b.audioSubs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.audioSubs, ch)
+		b.mu.Unlock()
+		close(ch)
+	}
+}
@@ -0,0 +1,153 @@
+package rtcegress
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// ParseAVCSequenceHeader parses an AVCDecoderConfigurationRecord (the FLV
+// AVCPacketType=0 payload) and returns the SPS/PPS NAL units plus the
+// length-field size used by subsequent AVCC NAL units in this stream.
+func ParseAVCSequenceHeader(payload []byte) (sps, pps []byte, nalLengthSize int, err error) {
+	if len(payload) < 7 {
+		return nil, nil, 0, errors.New("AVC sequence header too short")
+	}
+
+	nalLengthSize = int(payload[4]&0x03) + 1
+	numSPS := int(payload[5] & 0x1f)
+	off := 6
+
+	for i := 0; i < numSPS; i++ {
+		if off+2 > len(payload) {
+			return nil, nil, 0, errors.New("truncated SPS in sequence header")
+		}
+		n := int(binary.BigEndian.Uint16(payload[off : off+2]))
+		off += 2
+		if off+n > len(payload) {
+			return nil, nil, 0, errors.New("truncated SPS in sequence header")
+		}
+		sps = payload[off : off+n]
+		off += n
+	}
+
+	if off >= len(payload) {
+		return sps, nil, nalLengthSize, errors.New("missing PPS count in sequence header")
+	}
+	numPPS := int(payload[off])
+	off++
+
+	for i := 0; i < numPPS; i++ {
+		if off+2 > len(payload) {
+			return sps, nil, nalLengthSize, errors.New("truncated PPS in sequence header")
+		}
+		n := int(binary.BigEndian.Uint16(payload[off : off+2]))
+		off += 2
+		if off+n > len(payload) {
+			return sps, nil, nalLengthSize, errors.New("truncated PPS in sequence header")
+		}
+		pps = payload[off : off+n]
+		off += n
+	}
+
+	return sps, pps, nalLengthSize, nil
+}
+
+// BuildAVCSequenceHeader encodes sps/pps as an AVCDecoderConfigurationRecord
+// (the FLV AVCPacketType=0 payload), the inverse of ParseAVCSequenceHeader.
+// It always declares a 4-byte NAL length size, matching buildAVCC.
+func BuildAVCSequenceHeader(sps, pps []byte) ([]byte, error) {
+	if len(sps) < 4 {
+		return nil, errors.New("SPS too short")
+	}
+
+	out := []byte{
+		1,      // configurationVersion
+		sps[1], // AVCProfileIndication
+		sps[2], // profile_compatibility
+		sps[3], // AVCLevelIndication
+		0xFF,   // reserved(6) + lengthSizeMinusOne(2) = 3, i.e. 4-byte lengths
+		0xE1,   // reserved(3) + numOfSequenceParameterSets(5) = 1
+	}
+	out = append(out, byte(len(sps)>>8), byte(len(sps)))
+	out = append(out, sps...)
+	out = append(out, 1) // numOfPictureParameterSets
+	out = append(out, byte(len(pps)>>8), byte(len(pps)))
+	out = append(out, pps...)
+	return out, nil
+}
+
+// FindParameterSets scans an access unit's NAL units for an SPS (type 7)
+// and PPS (type 8), as sent inline ahead of an IDR by WHIP publishers that
+// repeat parameter sets. ok is false if either is missing.
+func FindParameterSets(nalus [][]byte) (sps, pps []byte, ok bool) {
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+		switch nalu[0] & 0x1f {
+		case 7:
+			sps = nalu
+		case 8:
+			pps = nalu
+		}
+	}
+	return sps, pps, len(sps) > 0 && len(pps) > 0
+}
+
+// SplitAVCCNALUs splits an AVCC-framed payload (length-prefixed NAL units,
+// as carried in FLV AVCPacketType=1 tags) into individual NAL units.
+func SplitAVCCNALUs(payload []byte, nalLengthSize int) ([][]byte, error) {
+	var nalus [][]byte
+	off := 0
+	for off < len(payload) {
+		if off+nalLengthSize > len(payload) {
+			return nil, errors.New("truncated NAL length prefix")
+		}
+
+		var n int
+		switch nalLengthSize {
+		case 1:
+			n = int(payload[off])
+		case 2:
+			n = int(binary.BigEndian.Uint16(payload[off : off+2]))
+		case 4:
+			n = int(binary.BigEndian.Uint32(payload[off : off+4]))
+		default:
+			return nil, errors.Errorf("unsupported NAL length size %d", nalLengthSize)
+		}
+		off += nalLengthSize
+
+		if off+n > len(payload) {
+			return nil, errors.New("truncated NAL unit")
+		}
+		nalus = append(nalus, payload[off:off+n])
+		off += n
+	}
+	return nalus, nil
+}
+
+// IsKeyframeNALU reports whether nalus contains an IDR slice (NAL type 5).
+func IsKeyframeNALU(nalus [][]byte) bool {
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+		if nalu[0]&0x1f == 5 {
+			return true
+		}
+	}
+	return false
+}
+
+// AnnexB prepends a start code to each NAL unit and concatenates them,
+// which is the framing pion's TrackLocalStaticSample expects for H.264.
+func AnnexB(nalus [][]byte) []byte {
+	var out []byte
+	startCode := []byte{0, 0, 0, 1}
+	for _, nalu := range nalus {
+		out = append(out, startCode...)
+		out = append(out, nalu...)
+	}
+	return out
+}
@@ -0,0 +1,48 @@
+package rtcegress
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBuildAVCSequenceHeaderRoundTrip(t *testing.T) {
+	sps := []byte{0x67, 0x42, 0xC0, 0x1E, 0xAB, 0xCD}
+	pps := []byte{0x68, 0xCE, 0x38, 0x80}
+
+	header, err := BuildAVCSequenceHeader(sps, pps)
+	if err != nil {
+		t.Fatalf("BuildAVCSequenceHeader() error = %v", err)
+	}
+
+	gotSPS, gotPPS, nalLengthSize, err := ParseAVCSequenceHeader(header)
+	if err != nil {
+		t.Fatalf("ParseAVCSequenceHeader() error = %v", err)
+	}
+	if !bytes.Equal(gotSPS, sps) {
+		t.Errorf("sps = %x, want %x", gotSPS, sps)
+	}
+	if !bytes.Equal(gotPPS, pps) {
+		t.Errorf("pps = %x, want %x", gotPPS, pps)
+	}
+	if nalLengthSize != 4 {
+		t.Errorf("nalLengthSize = %d, want 4", nalLengthSize)
+	}
+}
+
+func TestFindParameterSets(t *testing.T) {
+	sps := []byte{0x67, 0x42, 0xC0, 0x1E}
+	pps := []byte{0x68, 0xCE, 0x38, 0x80}
+	idr := []byte{0x65, 0x88, 0x84}
+
+	gotSPS, gotPPS, ok := FindParameterSets([][]byte{sps, pps, idr})
+	if !ok {
+		t.Fatal("FindParameterSets() ok = false, want true")
+	}
+	if !bytes.Equal(gotSPS, sps) || !bytes.Equal(gotPPS, pps) {
+		t.Errorf("FindParameterSets() = (%x, %x), want (%x, %x)", gotSPS, gotPPS, sps, pps)
+	}
+
+	if _, _, ok := FindParameterSets([][]byte{idr}); ok {
+		t.Error("FindParameterSets() ok = true for access unit with no SPS/PPS, want false")
+	}
+}
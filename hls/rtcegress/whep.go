@@ -0,0 +1,111 @@
+package rtcegress
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pkg/errors"
+)
+
+// Lookup resolves a stream key to the broadcaster feeding it, as tracked by
+// the caller's stream registry.
+type Lookup func(key string) (*MediaBroadcaster, bool)
+
+// WHEPHandler serves POST /{key}/whep: the request body is a SDP offer, the
+// response body the SDP answer, per the WHEP draft. A fresh PeerConnection
+// is created per viewer and torn down when it disconnects.
+func WHEPHandler(lookup Lookup) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), "/whep")
+
+		broadcaster, ok := lookup(key)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		offer, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "failed to read offer", http.StatusBadRequest)
+			return
+		}
+
+		answer, err := serveViewer(broadcaster, string(offer))
+		if err != nil {
+			http.Error(w, errors.Wrap(err, "failed to negotiate WHEP session").Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/sdp")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(answer))
+	}
+}
+
+func serveViewer(broadcaster *MediaBroadcaster, offerSDP string) (string, error) {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return "", err
+	}
+
+	videoTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", "watchify")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := pc.AddTrack(videoTrack); err != nil {
+		return "", err
+	}
+
+	// WHEP is video-only for now: the broadcaster's audio samples are AAC
+	// (decoded straight off the FLV publish), but WebRTC has no AAC RTP
+	// payload format and browsers only accept Opus. Forwarding the AAC bytes
+	// on an Opus-declared track wouldn't negotiate (Opus and AAC aren't
+	// bitstream-compatible) — it'd just silently produce noise. Wiring up a
+	// real AAC->Opus transcode is future work.
+	videoCh, sps, pps, unsubVideo := broadcaster.SubscribeVideo()
+
+	var tornDown int32
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateClosed || state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateDisconnected {
+			if atomic.CompareAndSwapInt32(&tornDown, 0, 1) {
+				unsubVideo()
+				_ = pc.Close()
+			}
+		}
+	})
+
+	go func() {
+		for s := range videoCh {
+			nalus := s.Data
+			if s.Keyframe && len(sps) > 0 && len(pps) > 0 {
+				// The browser's decoder can only be initialized from SPS/PPS
+				// carried in-band; prepend them to every keyframe so a viewer
+				// that joins mid-stream, or whose decoder drops them, recovers.
+				nalus = append([][]byte{sps, pps}, nalus...)
+			}
+			_ = videoTrack.WriteSample(media.Sample{Data: AnnexB(nalus), Duration: s.Duration})
+		}
+	}()
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offerSDP}); err != nil {
+		return "", err
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+	<-gatherComplete
+
+	return pc.LocalDescription().SDP, nil
+}
@@ -0,0 +1,128 @@
+package rtcegress
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pkg/errors"
+)
+
+// Ingest receives the H.264/AAC access units decoded from an incoming WHIP
+// publish, so the caller can feed them into the same FLV pipeline an RTMP
+// publish would use.
+type Ingest interface {
+	OnVideoAccessUnit(nalus [][]byte, keyframe bool)
+	OnAudioFrame(data []byte)
+	Close()
+}
+
+// BeginIngest resolves a stream key to an Ingest sink. It should apply the
+// same key-allow-list/overtake rules as the RTMP publish path.
+type BeginIngest func(key string) (Ingest, error)
+
+// WHIPHandler serves POST /{key}/whip: the request body is a SDP offer from
+// a WebRTC publisher (e.g. OBS's WHIP output), the response the SDP answer.
+// Incoming RTP is depacketized into access units and handed to the Ingest
+// returned by begin.
+func WHIPHandler(begin BeginIngest) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), "/whip")
+
+		ingest, err := begin(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		offer, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "failed to read offer", http.StatusBadRequest)
+			return
+		}
+
+		answer, err := acceptPublisher(ingest, string(offer))
+		if err != nil {
+			http.Error(w, errors.Wrap(err, "failed to negotiate WHIP session").Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/sdp")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(answer))
+	}
+}
+
+func acceptPublisher(ingest Ingest, offerSDP string) (string, error) {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return "", err
+	}
+
+	pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		switch track.Kind() {
+		case webrtc.RTPCodecTypeVideo:
+			depacketizeVideo(track, ingest)
+		case webrtc.RTPCodecTypeAudio:
+			depacketizeAudio(track, ingest)
+		}
+	})
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateClosed || state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateDisconnected {
+			ingest.Close()
+		}
+	})
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offerSDP}); err != nil {
+		return "", err
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+	<-gatherComplete
+
+	return pc.LocalDescription().SDP, nil
+}
+
+func depacketizeVideo(track *webrtc.TrackRemote, ingest Ingest) {
+	depacketizer := &codecs.H264Packet{}
+	var accessUnit [][]byte
+
+	for {
+		pkt, _, err := track.ReadRTP()
+		if err != nil {
+			return
+		}
+
+		nalu, err := depacketizer.Unmarshal(pkt.Payload)
+		if err != nil || len(nalu) == 0 {
+			continue
+		}
+		accessUnit = append(accessUnit, nalu)
+
+		if pkt.Marker {
+			ingest.OnVideoAccessUnit(accessUnit, IsKeyframeNALU(accessUnit))
+			accessUnit = nil
+		}
+	}
+}
+
+func depacketizeAudio(track *webrtc.TrackRemote, ingest Ingest) {
+	for {
+		pkt, _, err := track.ReadRTP()
+		if err != nil {
+			return
+		}
+		ingest.OnAudioFrame(pkt.Payload)
+	}
+}
@@ -0,0 +1,37 @@
+package segmenter
+
+// audioConfig holds the bits of an AAC AudioSpecificConfig needed to build
+// ADTS headers for the TS-muxed elementary stream.
+type audioConfig struct {
+	profile         byte // MPEG-4 audio object type minus 1, as ADTS encodes it
+	samplingFreqIdx byte
+	channelConfig   byte
+}
+
+// parseAudioSpecificConfig decodes the two-byte (no SBR/PS extension) form
+// of an AAC AudioSpecificConfig, as produced by the AAC sequence header FLV
+// tag (AACPacketType 0).
+func parseAudioSpecificConfig(asc []byte) audioConfig {
+	if len(asc) < 2 {
+		return audioConfig{profile: 1, samplingFreqIdx: 4, channelConfig: 2} // AAC LC, 44.1kHz, stereo
+	}
+	objectType := asc[0] >> 3
+	freqIdx := (asc[0]&0x07)<<1 | asc[1]>>7
+	channelConfig := (asc[1] >> 3) & 0x0f
+	return audioConfig{profile: objectType - 1, samplingFreqIdx: freqIdx, channelConfig: channelConfig}
+}
+
+// adtsHeader builds the 7-byte ADTS header needed to make a raw AAC frame
+// self-describing inside an MPEG-TS PES packet.
+func adtsHeader(cfg audioConfig, frameLen int) []byte {
+	total := frameLen + 7
+	h := make([]byte, 7)
+	h[0] = 0xFF
+	h[1] = 0xF1 // MPEG-4, no CRC
+	h[2] = cfg.profile<<6 | cfg.samplingFreqIdx<<2 | (cfg.channelConfig>>2)&0x01
+	h[3] = (cfg.channelConfig&0x03)<<6 | byte(total>>11)&0x03
+	h[4] = byte(total >> 3)
+	h[5] = byte(total<<5) | 0x1F
+	h[6] = 0xFC
+	return h
+}
@@ -0,0 +1,72 @@
+package segmenter
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+type segmentEntry struct {
+	name          string
+	duration      time.Duration
+	programDate   time.Time
+	discontinuity bool
+}
+
+// playlist is a sliding-window HLS media playlist, mirroring the
+// hls_list_size/delete_segments behavior the previous ffmpeg pipeline had.
+type playlist struct {
+	maxSegments int
+	mediaSeq    int
+	segments    []segmentEntry
+}
+
+func newPlaylist(maxSegments int) *playlist {
+	return &playlist{maxSegments: maxSegments}
+}
+
+func (p *playlist) addSegment(name string, duration time.Duration, discontinuity bool) {
+	p.segments = append(p.segments, segmentEntry{
+		name:          name,
+		duration:      duration,
+		programDate:   time.Now(),
+		discontinuity: discontinuity,
+	})
+	if len(p.segments) > p.maxSegments {
+		p.segments = p.segments[1:]
+		p.mediaSeq++
+	}
+}
+
+// write emits the rolling media playlist. #EXT-X-MAP is intentionally not
+// written: it points clients at an initialization segment carrying the
+// moov box, which only applies to fMP4 media segments. These are plain
+// MPEG-TS segments, each self-contained with its own PAT/PMT, so there is
+// no init segment for #EXT-X-MAP to reference.
+func (p *playlist) write(w io.Writer) error {
+	fmt.Fprintln(w, "#EXTM3U")
+	fmt.Fprintln(w, "#EXT-X-VERSION:4")
+	fmt.Fprintf(w, "#EXT-X-TARGETDURATION:%d\n", targetDurationSeconds(p.segments))
+	fmt.Fprintf(w, "#EXT-X-MEDIA-SEQUENCE:%d\n", p.mediaSeq)
+
+	for _, seg := range p.segments {
+		if seg.discontinuity {
+			fmt.Fprintln(w, "#EXT-X-DISCONTINUITY")
+		}
+		fmt.Fprintf(w, "#EXT-X-PROGRAM-DATE-TIME:%s\n", seg.programDate.Format(time.RFC3339Nano))
+		fmt.Fprintf(w, "#EXTINF:%.3f,\n", seg.duration.Seconds())
+		fmt.Fprintln(w, seg.name)
+	}
+
+	return nil
+}
+
+func targetDurationSeconds(segments []segmentEntry) int {
+	max := 2
+	for _, seg := range segments {
+		if s := int(seg.duration.Seconds() + 0.5); s > max {
+			max = s
+		}
+	}
+	return max
+}
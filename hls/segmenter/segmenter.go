@@ -0,0 +1,220 @@
+// Package segmenter is a pure-Go replacement for the ffmpeg-based HLS
+// pipeline: it muxes the H.264/AAC access units already parsed out of the
+// incoming FLV tags directly into MPEG-TS segments and maintains a rolling
+// index.m3u8, with no external process involved.
+package segmenter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/asticode/go-astits"
+	"github.com/pkg/errors"
+
+	"github.com/archroid/watchify/hls/rtcegress"
+)
+
+const (
+	videoPID = 256
+	audioPID = 257
+	pmtPID   = 4096
+)
+
+// Segmenter consumes one publish's video/audio access units and writes
+// MPEG-TS segments plus a rolling HLS playlist to dir.
+type Segmenter struct {
+	dir            string
+	targetDuration time.Duration
+
+	mu           sync.Mutex
+	playlist     *playlist
+	muxer        *astits.Muxer
+	file         *os.File
+	segmentStart time.Duration
+	lastPTS      time.Duration
+	segmentSeq   int
+
+	audioCfg     audioConfig
+	haveAudioCfg bool
+
+	lastCodecChange bool
+}
+
+// New creates a segmenter that writes into dir, which must already exist.
+func New(dir string, targetDuration time.Duration) *Segmenter {
+	return &Segmenter{
+		dir:            dir,
+		targetDuration: targetDuration,
+		playlist:       newPlaylist(5),
+	}
+}
+
+// SetAudioConfig records the AudioSpecificConfig carried in the AAC
+// sequence header, used to build ADTS headers for TS audio PES packets.
+func (s *Segmenter) SetAudioConfig(asc []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audioCfg = parseAudioSpecificConfig(asc)
+	s.haveAudioCfg = true
+}
+
+// WriteVideo muxes one H.264 access unit at presentation time pts. A new
+// segment starts on the first keyframe once the current segment has run
+// for at least targetDuration.
+func (s *Segmenter) WriteVideo(pts time.Duration, nalus [][]byte, keyframe bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.muxer == nil {
+		if !keyframe {
+			return nil // wait for the first IDR before opening a segment
+		}
+		if err := s.openSegmentLocked(pts); err != nil {
+			return err
+		}
+	} else if keyframe && pts-s.segmentStart >= s.targetDuration {
+		if err := s.closeSegmentLocked(s.lastPTS - s.segmentStart); err != nil {
+			return err
+		}
+		if err := s.openSegmentLocked(pts); err != nil {
+			return err
+		}
+	}
+	s.lastPTS = pts
+
+	data := rtcegress.AnnexB(nalus)
+	_, err := s.muxer.WriteData(&astits.MuxerData{
+		PID: videoPID,
+		AdaptationField: &astits.PacketAdaptationField{
+			RandomAccessIndicator: keyframe,
+		},
+		PES: &astits.PESData{
+			// Header.StreamID is left zero; the muxer fills it in from the
+			// PID's registered StreamType (see AddElementaryStream below).
+			Header: &astits.PESHeader{
+				OptionalHeader: &astits.PESOptionalHeader{
+					MarkerBits:      2,
+					PTSDTSIndicator: astits.PTSDTSIndicatorOnlyPTS,
+					PTS:             &astits.ClockReference{Base: pts.Milliseconds() * 90},
+				},
+			},
+			Data: data,
+		},
+	})
+	return err
+}
+
+// WriteAudio muxes one raw AAC frame at presentation time pts.
+func (s *Segmenter) WriteAudio(pts time.Duration, aac []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.muxer == nil {
+		return nil // wait for video to open the first segment
+	}
+
+	frame := aac
+	if s.haveAudioCfg {
+		frame = append(adtsHeader(s.audioCfg, len(aac)), aac...)
+	}
+
+	_, err := s.muxer.WriteData(&astits.MuxerData{
+		PID: audioPID,
+		PES: &astits.PESData{
+			Header: &astits.PESHeader{
+				OptionalHeader: &astits.PESOptionalHeader{
+					MarkerBits:      2,
+					PTSDTSIndicator: astits.PTSDTSIndicatorOnlyPTS,
+					PTS:             &astits.ClockReference{Base: pts.Milliseconds() * 90},
+				},
+			},
+			Data: frame,
+		},
+	})
+	return err
+}
+
+// MarkDiscontinuity flags the segment that is open when it is called (or,
+// if none is open, the next one opened) so the playlist writes
+// #EXT-X-DISCONTINUITY ahead of it, for use when the SPS/PPS or audio
+// config changes mid-stream.
+func (s *Segmenter) MarkDiscontinuity() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastCodecChange = true
+}
+
+// Close finalizes whatever segment is open.
+func (s *Segmenter) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeSegmentLocked(s.lastPTS - s.segmentStart)
+}
+
+func (s *Segmenter) openSegmentLocked(pts time.Duration) error {
+	name := fmt.Sprintf("%d.ts", s.segmentSeq)
+	f, err := os.Create(filepath.Join(s.dir, name))
+	if err != nil {
+		return errors.Wrap(err, "Failed to create TS segment")
+	}
+
+	muxer := astits.NewMuxer(nil, f)
+	if err := muxer.AddElementaryStream(astits.PMTElementaryStream{ElementaryPID: videoPID, StreamType: astits.StreamTypeH264Video}); err != nil {
+		f.Close()
+		return errors.Wrap(err, "Failed to add video stream")
+	}
+	if err := muxer.AddElementaryStream(astits.PMTElementaryStream{ElementaryPID: audioPID, StreamType: astits.StreamTypeAACAudio}); err != nil {
+		f.Close()
+		return errors.Wrap(err, "Failed to add audio stream")
+	}
+	muxer.SetPCRPID(videoPID)
+	if _, err := muxer.WriteTables(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "Failed to write PAT/PMT")
+	}
+
+	s.file = f
+	s.muxer = muxer
+	s.segmentStart = pts
+
+	return nil
+}
+
+// closeSegmentLocked finalizes the open segment, recording duration as its
+// #EXTINF so it reflects the actual elapsed PTS rather than targetDuration,
+// which a segment almost always runs past (a new one only opens once the
+// current segment has run for at least targetDuration).
+func (s *Segmenter) closeSegmentLocked(duration time.Duration) error {
+	if s.muxer == nil {
+		return nil
+	}
+	if duration < 0 {
+		duration = 0
+	}
+
+	name := fmt.Sprintf("%d.ts", s.segmentSeq)
+	s.playlist.addSegment(name, duration, s.lastCodecChange)
+	s.lastCodecChange = false
+
+	if err := s.writePlaylistLocked(); err != nil {
+		return err
+	}
+
+	err := s.file.Close()
+	s.file = nil
+	s.muxer = nil
+	s.segmentSeq++
+	return err
+}
+
+func (s *Segmenter) writePlaylistLocked() error {
+	f, err := os.Create(filepath.Join(s.dir, "index.m3u8"))
+	if err != nil {
+		return errors.Wrap(err, "Failed to write playlist")
+	}
+	defer f.Close()
+	return s.playlist.write(f)
+}
@@ -0,0 +1,44 @@
+package segmenter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSegmenterReportsActualElapsedDuration(t *testing.T) {
+	dir := t.TempDir()
+	s := New(dir, 2*time.Second)
+
+	idr := [][]byte{{0x65, 0x88}}
+	inter := [][]byte{{0x41, 0x9a}}
+
+	mustWrite := func(pts time.Duration, nalus [][]byte, keyframe bool) {
+		t.Helper()
+		if err := s.WriteVideo(pts, nalus, keyframe); err != nil {
+			t.Fatalf("WriteVideo(%v) error = %v", pts, err)
+		}
+	}
+
+	mustWrite(0, idr, true)
+	mustWrite(500*time.Millisecond, inter, false)
+	mustWrite(1000*time.Millisecond, inter, false)
+	// Only opens a new segment once the current one has run past
+	// targetDuration (2s); this keyframe arrives at 2.5s, but the last frame
+	// actually written to segment 0 was at 1s.
+	mustWrite(2500*time.Millisecond, idr, true)
+
+	playlist, err := os.ReadFile(filepath.Join(dir, "index.m3u8"))
+	if err != nil {
+		t.Fatalf("ReadFile(index.m3u8) error = %v", err)
+	}
+
+	if strings.Contains(string(playlist), "#EXTINF:2.000,") {
+		t.Errorf("playlist reports the fixed targetDuration instead of actual elapsed time:\n%s", playlist)
+	}
+	if !strings.Contains(string(playlist), "#EXTINF:1.000,") {
+		t.Errorf("playlist missing the expected 1.000s EXTINF for segment 0:\n%s", playlist)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// publisherView is the JSON representation of a live publisher returned by
+// the /streams endpoint.
+type publisherView struct {
+	Key       string `json:"key"`
+	ID        string `json:"id"`
+	StartedAt string `json:"startedAt"`
+	Bitrate   int64  `json:"bitrate"`
+}
+
+// streamsHandler serves GET /streams, listing all currently live publishers.
+func streamsHandler(registry *StreamRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sessions := registry.List()
+
+		publishers := make([]publisherView, 0, len(sessions))
+		for _, s := range sessions {
+			publishers = append(publishers, publisherView{
+				Key:       s.Key,
+				ID:        s.ID,
+				StartedAt: s.StartedAt.Format("2006-01-02T15:04:05Z07:00"),
+				Bitrate:   s.Bitrate(),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Publishers []publisherView `json:"publishers"`
+		}{Publishers: publishers})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/archroid/watchify/hls/abr"
+	"github.com/archroid/watchify/hls/rtcegress"
+)
+
+// whipIngest adapts a WHIP publisher's decoded video access units back into
+// raw FLV VIDEODATA tag bodies and replays them through Handler's regular
+// OnVideo path, so WHIP video shares the exact same HLS/HTTP-FLV/WHEP
+// fan-out an RTMP publish uses. WHIP audio is dropped (see OnAudioFrame).
+type whipIngest struct {
+	h     *Handler
+	start time.Time
+
+	sentVideoConfig    bool
+	warnedAudioDropped bool
+}
+
+// newWHIPIngest starts the publish pipeline for key and returns an Ingest
+// that feeds it, or an error if key is unknown or already publishing.
+func newWHIPIngest(cfg *Config, registry *StreamRegistry, abrManager *abr.Manager, key string) (*whipIngest, error) {
+	h := &Handler{cfg: cfg, registry: registry, abrManager: abrManager}
+	if err := h.startPublish(key); err != nil {
+		return nil, err
+	}
+	return &whipIngest{h: h, start: time.Now()}, nil
+}
+
+func (w *whipIngest) Close() {
+	w.h.OnClose()
+}
+
+func (w *whipIngest) timestamp() uint32 {
+	return uint32(time.Since(w.start).Milliseconds())
+}
+
+func (w *whipIngest) OnVideoAccessUnit(nalus [][]byte, keyframe bool) {
+	if !w.sentVideoConfig {
+		sps, pps, ok := rtcegress.FindParameterSets(nalus)
+		if !ok {
+			if keyframe {
+				log.Printf("WHIP keyframe arrived without inline SPS/PPS; decoders can't be primed until the publisher repeats them")
+			}
+			return // every downstream consumer needs the AVC sequence header first
+		}
+		if err := w.sendAVCSequenceHeader(sps, pps); err != nil {
+			log.Printf("Failed to build AVC sequence header for WHIP ingest: %+v", err)
+			return
+		}
+		w.sentVideoConfig = true
+	}
+
+	frameType := byte(2) // FLV VIDEODATA FrameType: inter frame
+	if keyframe {
+		frameType = 1 // key frame
+	}
+
+	var body bytes.Buffer
+	body.WriteByte(frameType<<4 | 7) // codec ID 7 = AVC
+	body.WriteByte(1)                // AVCPacketType 1 = NALU
+	body.Write([]byte{0, 0, 0})      // composition time offset
+	body.Write(buildAVCC(nalus))
+
+	if err := w.h.OnVideo(w.timestamp(), &body); err != nil {
+		log.Printf("Failed to ingest WHIP video sample: %+v", err)
+	}
+}
+
+// sendAVCSequenceHeader replays sps/pps through OnVideo as an
+// AVCPacketType=0 tag, the decoder config every NALU tag depends on.
+func (w *whipIngest) sendAVCSequenceHeader(sps, pps []byte) error {
+	record, err := rtcegress.BuildAVCSequenceHeader(sps, pps)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	body.WriteByte(1<<4 | 7)    // FrameType keyframe, codec ID 7 = AVC
+	body.WriteByte(0)           // AVCPacketType 0 = sequence header
+	body.Write([]byte{0, 0, 0}) // composition time offset
+	body.Write(record)
+
+	return w.h.OnVideo(w.timestamp(), &body)
+}
+
+// OnAudioFrame receives whatever audio codec the browser's WHIP offer
+// actually negotiated, which for WebRTC is always Opus (there is no
+// standard AAC RTP payload format, so pion's default MediaEngine never
+// offers it). The FLV/HLS/recording pipeline downstream of OnAudio only
+// understands AAC, and there's no Opus decoder or AAC encoder wired in
+// here, so rather than repeat the previous mislabeling bug (tagging raw
+// Opus bytes as AAC and corrupting every downstream consumer) WHIP audio
+// is dropped until a real Opus->AAC transcode is added.
+func (w *whipIngest) OnAudioFrame(data []byte) {
+	if !w.warnedAudioDropped {
+		log.Printf("WHIP publish audio is Opus, which the FLV/HLS pipeline can't decode yet; dropping audio for this publish")
+		w.warnedAudioDropped = true
+	}
+}
+
+func buildAVCC(nalus [][]byte) []byte {
+	var out []byte
+	for _, nalu := range nalus {
+		var length [4]byte
+		length[0] = byte(len(nalu) >> 24)
+		length[1] = byte(len(nalu) >> 16)
+		length[2] = byte(len(nalu) >> 8)
+		length[3] = byte(len(nalu))
+		out = append(out, length[:]...)
+		out = append(out, nalu...)
+	}
+	return out
+}